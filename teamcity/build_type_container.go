@@ -0,0 +1,102 @@
+package teamcity
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ContainerReuseScope controls the lifetime of a container started for a
+// StepCommandLine. See ContainerDefinition.ReuseScope.
+type ContainerReuseScope string
+
+const (
+	// ScopeStep starts a fresh container for the step alone and tears it
+	// down once the step finishes. This is the default.
+	ScopeStep ContainerReuseScope = "step"
+
+	// ScopeBuild keeps one container running for the whole build, so state
+	// such as installed tools or a working directory persists between the
+	// steps that reference it.
+	ScopeBuild ContainerReuseScope = "build"
+)
+
+// Step is implemented by every build step type, so a BuildType can hold a
+// heterogeneous list of them in Steps.
+type Step interface {
+	GetID() string
+	GetName() string
+	Type() BuildStepType
+}
+
+// BuildType represents a TeamCity build configuration.
+type BuildType struct {
+	ID    string
+	Name  string
+	Steps []Step
+
+	sharedContainersMu sync.Mutex
+	sharedContainers   map[string]ContainerDefinition
+}
+
+// SetSharedContainer registers container as a ScopeBuild container for bt, so any
+// StepCommandLine in bt whose Container.ReuseScope is ScopeBuild and whose
+// Container.Name matches can reference it instead of declaring its own image. It
+// returns an error if a step already referencing this name disagrees on image or
+// platform; a referencing step is expected to leave ImageReference empty, so only
+// steps that do set one are checked.
+func (bt *BuildType) SetSharedContainer(container ContainerDefinition) error {
+	if container.Name == "" {
+		return fmt.Errorf("shared container requires a name")
+	}
+
+	for _, step := range bt.Steps {
+		cmd, ok := step.(*StepCommandLine)
+		if !ok || cmd.Container.ReuseScope != ScopeBuild || cmd.Container.Name != container.Name {
+			continue
+		}
+
+		if cmd.Container.ImageReference != "" && (cmd.Container.ImageReference != container.ImageReference || cmd.Container.ImagePlatform != container.ImagePlatform) {
+			return fmt.Errorf("step %q disagrees with shared container %q on image/platform", cmd.Name, container.Name)
+		}
+	}
+
+	bt.sharedContainersMu.Lock()
+	defer bt.sharedContainersMu.Unlock()
+	if bt.sharedContainers == nil {
+		bt.sharedContainers = map[string]ContainerDefinition{}
+	}
+	bt.sharedContainers[container.Name] = container
+
+	return nil
+}
+
+// GetSharedContainer returns the ScopeBuild container registered on bt under name,
+// and whether one was found.
+func (bt *BuildType) GetSharedContainer(name string) (ContainerDefinition, bool) {
+	bt.sharedContainersMu.Lock()
+	defer bt.sharedContainersMu.Unlock()
+	container, ok := bt.sharedContainers[name]
+	return container, ok
+}
+
+// ReferenceSharedContainer points step at the shared container registered under name
+// via SetSharedContainer and appends step to bt.Steps. Unlike SetSharedContainer,
+// which can only validate steps already present in bt.Steps, this validates
+// agreement on image/platform immediately, so the natural call order of
+// registering the container and then adding the steps that use it is still checked.
+func (bt *BuildType) ReferenceSharedContainer(step *StepCommandLine, name string) error {
+	container, ok := bt.GetSharedContainer(name)
+	if !ok {
+		return fmt.Errorf("no shared container named %q registered on build type", name)
+	}
+
+	if step.Container.ImageReference != "" && (step.Container.ImageReference != container.ImageReference || step.Container.ImagePlatform != container.ImagePlatform) {
+		return fmt.Errorf("step %q disagrees with shared container %q on image/platform", step.Name, name)
+	}
+
+	step.Container.ReuseScope = ScopeBuild
+	step.Container.Name = name
+	bt.Steps = append(bt.Steps, step)
+
+	return nil
+}
@@ -0,0 +1,128 @@
+package teamcity
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestBuildTypeSetSharedContainer(t *testing.T) {
+	t.Run("requires a name", func(t *testing.T) {
+		bt := &BuildType{}
+		if err := bt.SetSharedContainer(ContainerDefinition{ImageReference: "golang:1.21"}); err == nil {
+			t.Error("expected an error for an unnamed shared container, got nil")
+		}
+	})
+
+	t.Run("accepts a step that leaves ImageReference empty, per Container.Name's doc comment", func(t *testing.T) {
+		bt := &BuildType{}
+		step, _ := NewStepCommandLineScript("build", "go build ./...")
+		step.Container = ContainerDefinition{ReuseScope: ScopeBuild, Name: "tools"}
+		bt.Steps = []Step{step}
+
+		if err := bt.SetSharedContainer(ContainerDefinition{Name: "tools", ImageReference: "golang:1.21"}); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("rejects a step that explicitly disagrees on image", func(t *testing.T) {
+		bt := &BuildType{}
+		step, _ := NewStepCommandLineScript("build", "go build ./...")
+		step.Container = ContainerDefinition{ReuseScope: ScopeBuild, Name: "tools", ImageReference: "node:20"}
+		bt.Steps = []Step{step}
+
+		if err := bt.SetSharedContainer(ContainerDefinition{Name: "tools", ImageReference: "golang:1.21"}); err == nil {
+			t.Error("expected an error for a conflicting step, got nil")
+		}
+	})
+
+	t.Run("GetSharedContainer returns what was registered", func(t *testing.T) {
+		bt := &BuildType{}
+		want := ContainerDefinition{Name: "tools", ImageReference: "golang:1.21"}
+		if err := bt.SetSharedContainer(want); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		got, ok := bt.GetSharedContainer("tools")
+		if !ok {
+			t.Fatal("expected a registered shared container")
+		}
+		if got.ImageReference != want.ImageReference {
+			t.Errorf("ImageReference = %q, want %q", got.ImageReference, want.ImageReference)
+		}
+
+		if _, ok := bt.GetSharedContainer("missing"); ok {
+			t.Error("expected no shared container for an unregistered name")
+		}
+	})
+}
+
+func TestBuildTypeReferenceSharedContainer(t *testing.T) {
+	t.Run("errors when the name isn't registered yet", func(t *testing.T) {
+		bt := &BuildType{}
+		step, _ := NewStepCommandLineScript("build", "go build ./...")
+		if err := bt.ReferenceSharedContainer(step, "tools"); err == nil {
+			t.Error("expected an error for an unregistered shared container, got nil")
+		}
+	})
+
+	t.Run("appends the step and points it at the shared container", func(t *testing.T) {
+		bt := &BuildType{}
+		if err := bt.SetSharedContainer(ContainerDefinition{Name: "tools", ImageReference: "golang:1.21"}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		step, _ := NewStepCommandLineScript("build", "go build ./...")
+		if err := bt.ReferenceSharedContainer(step, "tools"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if step.Container.ReuseScope != ScopeBuild || step.Container.Name != "tools" {
+			t.Errorf("Container = %+v, want ReuseScope=ScopeBuild Name=tools", step.Container)
+		}
+		if len(bt.Steps) != 1 || bt.Steps[0] != Step(step) {
+			t.Errorf("Steps = %v, want [step]", bt.Steps)
+		}
+	})
+
+	t.Run("rejects a step that already disagrees on image, even before it's added", func(t *testing.T) {
+		bt := &BuildType{}
+		if err := bt.SetSharedContainer(ContainerDefinition{Name: "tools", ImageReference: "golang:1.21"}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		step, _ := NewStepCommandLineScript("build", "go build ./...")
+		step.Container = ContainerDefinition{ImageReference: "node:20"}
+		if err := bt.ReferenceSharedContainer(step, "tools"); err == nil {
+			t.Error("expected an error for a conflicting step, got nil")
+		}
+		if len(bt.Steps) != 0 {
+			t.Errorf("Steps = %v, want the conflicting step not to have been appended", bt.Steps)
+		}
+	})
+}
+
+// TestBuildTypeSharedContainerConcurrentAccess exercises SetSharedContainer and
+// GetSharedContainer from multiple goroutines; run with -race to confirm the
+// mutex actually protects the registry.
+func TestBuildTypeSharedContainerConcurrentAccess(t *testing.T) {
+	bt := &BuildType{}
+	var wg sync.WaitGroup
+
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			_ = bt.SetSharedContainer(ContainerDefinition{Name: "tools", ImageReference: "golang:1.21"})
+		}(i)
+		go func() {
+			defer wg.Done()
+			bt.GetSharedContainer("tools")
+		}()
+	}
+
+	wg.Wait()
+
+	if _, ok := bt.GetSharedContainer("tools"); !ok {
+		t.Error("expected the shared container to be registered after concurrent access")
+	}
+}
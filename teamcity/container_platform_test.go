@@ -0,0 +1,123 @@
+package teamcity
+
+import "testing"
+
+func TestNewContainerPlatform(t *testing.T) {
+	tests := []struct {
+		name    string
+		os      string
+		arch    ContainerArchitecture
+		want    ContainerPlatform
+		wantErr bool
+	}{
+		{"linux with no arch", Linux, "", Linux, false},
+		{"linux with ArchAny", Linux, ArchAny, Linux, false},
+		{"linux/amd64", Linux, ArchAMD64, "linux/amd64", false},
+		{"linux/arm64", Linux, ArchARM64, "linux/arm64", false},
+		{"windows/amd64", Windows, ArchAMD64, "windows/amd64", false},
+		{"any with no arch", string(Any), "", Any, false},
+		{"unknown os", "plan9", ArchAMD64, "", true},
+		{"unknown arch", Linux, ContainerArchitecture("mips"), "", true},
+		{"any with a specific arch is invalid", string(Any), ArchAMD64, "", true},
+		{"windows/arm64 is unsupported", Windows, ArchARM64, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewContainerPlatform(tt.os, tt.arch)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got platform %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("NewContainerPlatform(%q, %q) = %q, want %q", tt.os, tt.arch, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseContainerPlatform(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    ContainerPlatform
+		wantErr bool
+	}{
+		{"coarse linux", "linux", Linux, false},
+		{"coarse windows", "windows", Windows, false},
+		{"linux/arm64", "linux/arm64", "linux/arm64", false},
+		{"unknown os", "plan9/amd64", "", true},
+		{"unknown arch", "linux/bogus", "", true},
+		{"extra path segment lands in arch and is rejected", "linux/arm64/extra", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseContainerPlatform(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got platform %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseContainerPlatform(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContainerDefinitionWithPlatform(t *testing.T) {
+	def := ContainerDefinition{
+		ImageReference: "golang:1.21",
+		PlatformMatrix: []ContainerPlatform{"linux/amd64", "linux/arm64"},
+	}
+
+	out := def.WithPlatform("linux/arm64")
+
+	if out.ImagePlatform != "linux/arm64" {
+		t.Errorf("ImagePlatform = %q, want %q", out.ImagePlatform, "linux/arm64")
+	}
+	if out.PlatformMatrix != nil {
+		t.Errorf("PlatformMatrix = %v, want nil", out.PlatformMatrix)
+	}
+	if len(def.PlatformMatrix) != 2 {
+		t.Errorf("original definition's PlatformMatrix was mutated: %v", def.PlatformMatrix)
+	}
+}
+
+// TestStepCommandLineImagePlatformRoundTrip guards against a minimal
+// ContainerDefinition (ImageReference set, ImagePlatform left at its zero
+// value) failing to round trip through Marshal/UnmarshalJSON.
+func TestStepCommandLineImagePlatformRoundTrip(t *testing.T) {
+	step, err := NewStepCommandLineScript("build", "go build ./...")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	step.Container = ContainerDefinition{ImageReference: "golang:1.21"}
+
+	data, err := step.MarshalJSON()
+	if err != nil {
+		t.Fatalf("unexpected error marshaling: %v", err)
+	}
+
+	var out StepCommandLine
+	if err := out.UnmarshalJSON(data); err != nil {
+		t.Fatalf("unexpected error unmarshaling: %v", err)
+	}
+
+	if out.Container.ImageReference != "golang:1.21" {
+		t.Errorf("ImageReference = %q, want %q", out.Container.ImageReference, "golang:1.21")
+	}
+	if out.Container.ImagePlatform != Any {
+		t.Errorf("ImagePlatform = %q, want %q", out.Container.ImagePlatform, Any)
+	}
+}
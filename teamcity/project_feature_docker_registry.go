@@ -0,0 +1,20 @@
+package teamcity
+
+// ProjectFeatureDockerRegistry returns a project-level connection feature
+// declaring a private docker registry credential, so the credential can be
+// set up once at project scope and consumed from a step by referencing
+// usernameParam/passwordParam in a RegistryCredentials, instead of every step
+// repeating the same registry connection properties.
+func ProjectFeatureDockerRegistry(id, registry, usernameParam, passwordParam string) ProjectFeature {
+	props := NewPropertiesEmpty()
+	props.AddOrReplaceValue("providerType", "Docker Registry")
+	props.AddOrReplaceValue("repository", registry)
+	props.AddOrReplaceValue("userName", paramRef(usernameParam))
+	props.AddOrReplaceValue("secure:password", paramRef(passwordParam))
+
+	return ProjectFeature{
+		ID:         id,
+		Type:       "OAuthProvider",
+		Properties: props,
+	}
+}
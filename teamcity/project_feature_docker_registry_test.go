@@ -0,0 +1,80 @@
+package teamcity
+
+import "testing"
+
+func TestProjectFeatureDockerRegistry(t *testing.T) {
+	feature := ProjectFeatureDockerRegistry("ghcr", "ghcr.io", "env.GHCR_USER", "env.GHCR_TOKEN")
+
+	if feature.ID != "ghcr" {
+		t.Errorf("ID = %q, want %q", feature.ID, "ghcr")
+	}
+	if feature.Type != "OAuthProvider" {
+		t.Errorf("Type = %q, want %q", feature.Type, "OAuthProvider")
+	}
+
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"providerType", "Docker Registry"},
+		{"repository", "ghcr.io"},
+		{"userName", "%env.GHCR_USER%"},
+		{"secure:password", "%env.GHCR_TOKEN%"},
+	}
+	for _, tt := range tests {
+		got, ok := feature.Properties.GetOk(tt.key)
+		if !ok {
+			t.Errorf("property %q not set", tt.key)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("property %q = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestStepCommandLineRegistryCredentials(t *testing.T) {
+	step, _ := NewStepCommandLineScript("build", "go build ./...")
+	step.Container = ContainerDefinition{
+		ImageReference: "ghcr.io/acme/builder:latest",
+		Credentials: &RegistryCredentials{
+			Registry:      "ghcr.io",
+			UsernameParam: "env.GHCR_USER",
+			PasswordParam: "env.GHCR_TOKEN",
+		},
+	}
+
+	data, err := step.MarshalJSON()
+	if err != nil {
+		t.Fatalf("unexpected error marshaling: %v", err)
+	}
+
+	var out StepCommandLine
+	if err := out.UnmarshalJSON(data); err != nil {
+		t.Fatalf("unexpected error unmarshaling: %v", err)
+	}
+
+	cred := out.Container.Credentials
+	if cred == nil {
+		t.Fatal("expected credentials to round trip, got nil")
+	}
+	if cred.Registry != "ghcr.io" {
+		t.Errorf("Registry = %q, want %q", cred.Registry, "ghcr.io")
+	}
+	if cred.UsernameParam != "env.GHCR_USER" {
+		t.Errorf("UsernameParam = %q, want %q", cred.UsernameParam, "env.GHCR_USER")
+	}
+	if cred.PasswordParam != "env.GHCR_TOKEN" {
+		t.Errorf("PasswordParam = %q, want %q", cred.PasswordParam, "env.GHCR_TOKEN")
+	}
+}
+
+func TestStepCommandLineNoCredentials(t *testing.T) {
+	step, _ := NewStepCommandLineScript("build", "go build ./...")
+	step.Container = ContainerDefinition{ImageReference: "golang:1.21"}
+
+	props := step.properties()
+	if _, ok := props.GetOk("plugin.docker.registry.url"); ok {
+		t.Error("did not expect registry properties without Credentials set")
+	}
+}
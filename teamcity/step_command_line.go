@@ -5,8 +5,12 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 )
 
+// ContainerPlatform represents the os, or os/arch pair, a container image
+// targets. It is serialized as TeamCity's plugin.docker.imagePlatform value, e.g.
+// "linux", "windows", or "linux/arm64".
 type ContainerPlatform string
 
 const (
@@ -15,6 +19,62 @@ const (
 	Windows                   = "windows"
 )
 
+// ContainerArchitecture represents the CPU architecture half of a
+// ContainerPlatform, e.g. "amd64" or "arm64".
+type ContainerArchitecture string
+
+const (
+	// ArchAny means no specific architecture has been selected.
+	ArchAny   ContainerArchitecture = "*"
+	ArchAMD64 ContainerArchitecture = "amd64"
+	ArchARM64 ContainerArchitecture = "arm64"
+)
+
+// NewContainerPlatform builds a ContainerPlatform from an os/arch pair,
+// e.g. NewContainerPlatform("linux", ArchARM64) yields "linux/arm64".
+// Passing ArchAny (or an empty arch) yields the coarse, os-only platform.
+// It returns an error if os is not a recognized value or if the arch is
+// not valid for that os.
+func NewContainerPlatform(os string, arch ContainerArchitecture) (ContainerPlatform, error) {
+	switch os {
+	case Linux, Windows, string(Any):
+	default:
+		return "", fmt.Errorf("unknown container platform os %q", os)
+	}
+
+	switch arch {
+	case ArchAny, ArchAMD64, ArchARM64, "":
+	default:
+		return "", fmt.Errorf("unknown container platform arch %q", arch)
+	}
+
+	if os == string(Any) && arch != ArchAny && arch != "" {
+		return "", fmt.Errorf("arch %q is not valid for the %q platform", arch, Any)
+	}
+
+	if os == Windows && arch == ArchARM64 {
+		return "", fmt.Errorf("arch %q is not supported on the %q platform", arch, Windows)
+	}
+
+	if arch == ArchAny || arch == "" {
+		return ContainerPlatform(os), nil
+	}
+
+	return ContainerPlatform(fmt.Sprintf("%s/%s", os, arch)), nil
+}
+
+// ParseContainerPlatform parses a plugin.docker.imagePlatform value (as emitted by
+// properties()) back into a ContainerPlatform, validating it the same way
+// NewContainerPlatform does.
+func ParseContainerPlatform(value string) (ContainerPlatform, error) {
+	os, arch, found := strings.Cut(value, "/")
+	if !found {
+		return NewContainerPlatform(os, ArchAny)
+	}
+
+	return NewContainerPlatform(os, ContainerArchitecture(arch))
+}
+
 // ContainerDefinition represents the container configuration that a command
 // line step will run within.
 type ContainerDefinition struct {
@@ -31,6 +91,62 @@ type ContainerDefinition struct {
 
 	// Additional arguments to add to the container run (i.e. docker run) command.
 	AdditionalContainerRunArguments string
+
+	// PlatformMatrix optionally lists additional platforms this container should be
+	// materialised against, e.g. when a caller wants to generate one step per
+	// entry of a build matrix. It is not serialized on its own; use
+	// WithPlatform to derive a per-platform ContainerDefinition from it.
+	PlatformMatrix []ContainerPlatform
+
+	// ReuseScope controls how long this container persists. The zero value
+	// behaves like ScopeStep and starts a fresh container for this step alone. ScopeBuild
+	// shares one long-lived container across every step in the build type
+	// that references it by Name; see BuildType.SetSharedContainer.
+	ReuseScope ContainerReuseScope
+
+	// Name identifies a ScopeBuild container so other steps in the same
+	// build type can reference it instead of declaring their own image.
+	Name string
+
+	// Credentials authenticates the pull of ImageReference against a private
+	// registry (ECR, GHCR, a private Harbor, etc). Nil means the image is
+	// pulled without authentication.
+	Credentials *RegistryCredentials
+}
+
+// RegistryCredentials references the TeamCity parameters holding the username and
+// password for a private container registry, rather than embedding raw secrets in
+// the step definition.
+type RegistryCredentials struct {
+	// Registry is the registry host (or connection ID) these credentials apply to.
+	Registry string
+
+	// UsernameParam is the name of the TeamCity parameter holding the registry username.
+	UsernameParam string
+
+	// PasswordParam is the name of the TeamCity parameter holding the registry password.
+	PasswordParam string
+}
+
+// paramRef wraps a TeamCity parameter name in the %...% reference syntax used to
+// point a property at a parameter instead of embedding its value directly.
+func paramRef(param string) string {
+	return fmt.Sprintf("%%%s%%", param)
+}
+
+// unparamRef strips the %...% reference syntax added by paramRef, returning the
+// bare parameter name.
+func unparamRef(ref string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(ref, "%"), "%")
+}
+
+// WithPlatform returns a copy of the container definition pinned to the
+// given platform, for materialising a single entry of PlatformMatrix.
+func (c ContainerDefinition) WithPlatform(platform ContainerPlatform) ContainerDefinition {
+	out := c
+	out.ImagePlatform = platform
+	out.PlatformMatrix = nil
+	return out
 }
 
 // StepCommandLine represents a a build step of type "CommandLine"
@@ -50,6 +166,61 @@ type StepCommandLine struct {
 	ExecuteMode StepExecuteMode
 	//Container is the definition of the container the step will run within.
 	Container ContainerDefinition
+	// shell is the shell the CustomScript was compiled for, when the step was
+	// built with NewStepCommandLineCommands. It is preserved across a
+	// marshal/unmarshal round trip via the teamcity.commandline.shell property.
+	shell ShellKind
+	// argv and quoting preserve the original argument list and quoting mode
+	// passed to NewStepCommandLineExecutableArgv, so a marshal/unmarshal round
+	// trip doesn't lose them even though CommandParameters only stores the
+	// already-joined string.
+	argv    []string
+	quoting ArgQuoting
+}
+
+// ArgQuoting selects the quoting convention NewStepCommandLineExecutableArgv uses
+// when joining argv into a single CommandParameters string.
+type ArgQuoting string
+
+const (
+	// QuotingPosix applies POSIX sh/bash single-quote quoting.
+	QuotingPosix ArgQuoting = "posix"
+	// QuotingWindowsCreateProcess applies the quoting rules the Windows
+	// CreateProcess API uses to split a command line back into argv.
+	QuotingWindowsCreateProcess ArgQuoting = "windows-createprocess"
+	// QuotingPowerShell applies PowerShell double-quote/backtick quoting.
+	QuotingPowerShell ArgQuoting = "powershell"
+)
+
+// ShellKind identifies the shell a compiled script targets, so
+// NewStepCommandLineCommands and ShellQuote know which quoting and
+// fail-fast conventions to apply.
+type ShellKind string
+
+const (
+	ShellPosix      ShellKind = "sh"
+	ShellBash       ShellKind = "bash"
+	ShellPowerShell ShellKind = "powershell"
+	ShellCmd        ShellKind = "cmd"
+)
+
+// ShellQuote quotes a single argument or command fragment for safe
+// inclusion in a script targeting shell.
+func ShellQuote(shell ShellKind, s string) string {
+	switch shell {
+	case ShellPosix, ShellBash:
+		return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+	case ShellPowerShell:
+		s = strings.ReplaceAll(s, "`", "``")
+		s = strings.ReplaceAll(s, `"`, "`\"")
+		s = strings.ReplaceAll(s, "$", "`$")
+		return `"` + s + `"`
+	case ShellCmd:
+		s = strings.ReplaceAll(s, "%", "%%")
+		return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+	default:
+		return s
+	}
 }
 
 // NewStepCommandLineScript creates a command line build step that runs an inline platform-specific script.
@@ -83,6 +254,152 @@ func NewStepCommandLineExecutable(name string, executable string, args string) (
 	}, nil
 }
 
+// NewStepCommandLineCommands creates a command line build step by compiling a list of
+// individual commands into a single, shell-appropriate fail-fast script, so callers don't
+// have to hand-write the platform-specific boilerplate that NewStepCommandLineScript requires.
+func NewStepCommandLineCommands(name string, commands []string, shell ShellKind) (*StepCommandLine, error) {
+	if len(commands) == 0 {
+		return nil, errors.New("at least one command is required")
+	}
+
+	var script string
+	switch shell {
+	case ShellPosix, ShellBash:
+		script = compilePosixScript(shell, commands)
+	case ShellPowerShell, ShellCmd:
+		script = compileWindowsScript(shell, commands)
+	default:
+		return nil, fmt.Errorf("unknown shell kind %q", shell)
+	}
+
+	step, err := NewStepCommandLineScript(name, script)
+	if err != nil {
+		return nil, err
+	}
+	step.shell = shell
+
+	return step, nil
+}
+
+// compilePosixScript compiles commands into a sh/bash script that stops on the first
+// failing command and echoes each command before it runs, mirroring `set -x` tracing.
+func compilePosixScript(shell ShellKind, commands []string) string {
+	var b strings.Builder
+	if shell == ShellBash {
+		b.WriteString("#!/usr/bin/env bash\n")
+	} else {
+		b.WriteString("#!/usr/bin/env sh\n")
+	}
+	b.WriteString("set -e\n")
+
+	for _, cmd := range commands {
+		fmt.Fprintf(&b, "echo + %s\n", ShellQuote(shell, cmd))
+		b.WriteString(cmd + "\n")
+	}
+
+	return b.String()
+}
+
+// compileWindowsScript compiles commands into a powershell or cmd script that stops on
+// the first failing command, since neither shell fails fast on a nonzero exit by default.
+func compileWindowsScript(shell ShellKind, commands []string) string {
+	var b strings.Builder
+
+	if shell == ShellPowerShell {
+		b.WriteString("$ErrorActionPreference = 'Stop'\n")
+		for _, cmd := range commands {
+			fmt.Fprintf(&b, "Write-Host %s\n", ShellQuote(shell, "+ "+cmd))
+			b.WriteString(cmd + "\n")
+			b.WriteString("if ($LASTEXITCODE -ne 0) { exit $LASTEXITCODE }\n")
+		}
+		return b.String()
+	}
+
+	b.WriteString("@echo off\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(&b, "echo + %s\n", cmd)
+		b.WriteString(cmd + "\n")
+		b.WriteString("if errorlevel 1 exit /b %errorlevel%\n")
+	}
+
+	return b.String()
+}
+
+// NewStepCommandLineExecutableArgv creates a command line step that invokes an
+// external executable, like NewStepCommandLineExecutable, but takes its arguments
+// as argv and joins them into CommandParameters using the correct quoting rules
+// for quoting, rather than requiring the caller to hand-craft the escaping.
+func NewStepCommandLineExecutableArgv(name string, executable string, argv []string, quoting ArgQuoting) (*StepCommandLine, error) {
+	params, err := quoteArgv(argv, quoting)
+	if err != nil {
+		return nil, err
+	}
+
+	step, err := NewStepCommandLineExecutable(name, executable, params)
+	if err != nil {
+		return nil, err
+	}
+	step.argv = argv
+	step.quoting = quoting
+
+	return step, nil
+}
+
+// quoteArgv joins argv into a single string, quoting each argument according to quoting.
+func quoteArgv(argv []string, quoting ArgQuoting) (string, error) {
+	quoted := make([]string, len(argv))
+	for i, arg := range argv {
+		switch quoting {
+		case QuotingPosix:
+			quoted[i] = ShellQuote(ShellPosix, arg)
+		case QuotingPowerShell:
+			quoted[i] = ShellQuote(ShellPowerShell, arg)
+		case QuotingWindowsCreateProcess:
+			quoted[i] = quoteCreateProcessArg(arg)
+		default:
+			return "", fmt.Errorf("unknown arg quoting %q", quoting)
+		}
+	}
+
+	return strings.Join(quoted, " "), nil
+}
+
+// quoteCreateProcessArg escapes a single argument using the same rules the Windows
+// CreateProcess API uses to split a command line back into argv: backslashes are
+// only doubled when they immediately precede a literal quote or end the argument,
+// and embedded quotes are escaped with a backslash.
+func quoteCreateProcessArg(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\n\v\"") {
+		return s
+	}
+
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); {
+		backslashes := 0
+		for i < len(s) && s[i] == '\\' {
+			backslashes++
+			i++
+		}
+
+		switch {
+		case i == len(s):
+			b.WriteString(strings.Repeat(`\`, backslashes*2))
+		case s[i] == '"':
+			b.WriteString(strings.Repeat(`\`, backslashes*2+1))
+			b.WriteByte('"')
+			i++
+		default:
+			b.WriteString(strings.Repeat(`\`, backslashes))
+			b.WriteByte(s[i])
+			i++
+		}
+	}
+	b.WriteByte('"')
+
+	return b.String()
+}
+
 func (s *StepCommandLine) GetContainer() ContainerDefinition {
 	return s.Container
 }
@@ -112,20 +429,33 @@ func (s *StepCommandLine) properties() *Properties {
 		if s.CommandParameters != "" {
 			props.AddOrReplaceValue("command.parameters", s.CommandParameters)
 		}
+
+		if len(s.argv) > 0 {
+			argv, _ := json.Marshal(s.argv)
+			props.AddOrReplaceValue("teamcity.commandline.argv", string(argv))
+			props.AddOrReplaceValue("teamcity.commandline.argvQuoting", string(s.quoting))
+		}
 	} else {
 		props.AddOrReplaceValue("script.content", s.CustomScript)
 		props.AddOrReplaceValue("use.custom.script", "true")
+
+		if s.shell != "" {
+			props.AddOrReplaceValue("teamcity.commandline.shell", string(s.shell))
+		}
 	}
 
 	// TODO: move the container property management to another function.
-	// If we don't have a container image reference, don't set any container
-	// properties.
-	if s.Container.ImageReference != "" {
+	switch {
+	case s.Container.ReuseScope == ScopeBuild:
+		// The container itself is registered on the build type; this step only
+		// needs to reference it by name, and may leave ImageReference empty.
+		props.AddOrReplaceValue("plugin.docker.container.ref", s.Container.Name)
+	case s.Container.ImageReference != "":
 		// Set the container image property.
 		props.AddOrReplaceValue("plugin.docker.imageId", s.Container.ImageReference)
 
 		// Only set the container platform if we've explicitly selected one.
-		if s.Container.ImagePlatform != Any {
+		if s.Container.ImagePlatform != Any && s.Container.ImagePlatform != "" {
 			props.AddOrReplaceValue("plugin.docker.imagePlatform", string(s.Container.ImagePlatform))
 		}
 
@@ -136,6 +466,14 @@ func (s *StepCommandLine) properties() *Properties {
 		if s.Container.AdditionalContainerRunArguments != "" {
 			props.AddOrReplaceValue("plugin.docker.run.parameters", s.Container.AdditionalContainerRunArguments)
 		}
+
+		// Wire up registry authentication so ExplicitlyPullImage can succeed
+		// against a private registry.
+		if cred := s.Container.Credentials; cred != nil {
+			props.AddOrReplaceValue("plugin.docker.registry.url", cred.Registry)
+			props.AddOrReplaceValue("dockerImage.username", paramRef(cred.UsernameParam))
+			props.AddOrReplaceValue("dockerImage.password", paramRef(cred.PasswordParam))
+		}
 	}
 
 	return props
@@ -176,6 +514,9 @@ func (s *StepCommandLine) UnmarshalJSON(data []byte) error {
 		if v, ok := props.GetOk("script.content"); ok {
 			s.CustomScript = v
 		}
+		if v, ok := props.GetOk("teamcity.commandline.shell"); ok {
+			s.shell = ShellKind(v)
+		}
 	}
 
 	if v, ok := props.GetOk("command.executable"); ok {
@@ -183,14 +524,48 @@ func (s *StepCommandLine) UnmarshalJSON(data []byte) error {
 		if v, ok := props.GetOk("command.parameters"); ok {
 			s.CommandParameters = v
 		}
+
+		if v, ok := props.GetOk("teamcity.commandline.argv"); ok {
+			var argv []string
+			if err := json.Unmarshal([]byte(v), &argv); err != nil {
+				return fmt.Errorf("deserializing command argv: %w", err)
+			}
+			s.argv = argv
+			if q, ok := props.GetOk("teamcity.commandline.argvQuoting"); ok {
+				s.quoting = ArgQuoting(q)
+			}
+		}
 	}
 
 	if v, ok := props.GetOk("teamcity.step.mode"); ok {
 		s.ExecuteMode = StepExecuteMode(v)
 	}
 
-	if v, ok := props.GetOk("plugin.docker.imageId"); ok {
+	if v, ok := props.GetOk("plugin.docker.container.ref"); ok {
+		s.Container.ReuseScope = ScopeBuild
+		s.Container.Name = v
+	} else if v, ok := props.GetOk("plugin.docker.imageId"); ok {
 		s.Container.ImageReference = v
+
+		s.Container.ImagePlatform = Any
+		if platform, ok := props.GetOk("plugin.docker.imagePlatform"); ok {
+			parsed, err := ParseContainerPlatform(platform)
+			if err != nil {
+				return fmt.Errorf("deserializing container platform: %w", err)
+			}
+			s.Container.ImagePlatform = parsed
+		}
+
+		if registry, ok := props.GetOk("plugin.docker.registry.url"); ok {
+			cred := &RegistryCredentials{Registry: registry}
+			if v, ok := props.GetOk("dockerImage.username"); ok {
+				cred.UsernameParam = unparamRef(v)
+			}
+			if v, ok := props.GetOk("dockerImage.password"); ok {
+				cred.PasswordParam = unparamRef(v)
+			}
+			s.Container.Credentials = cred
+		}
 	}
 
 	return nil
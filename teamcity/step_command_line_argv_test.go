@@ -0,0 +1,119 @@
+package teamcity
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestQuoteCreateProcessArg(t *testing.T) {
+	tests := []struct {
+		name string
+		arg  string
+		want string
+	}{
+		{"plain arg needs no quoting", "hello", "hello"},
+		{"empty string is quoted", "", `""`},
+		{"embedded space forces quoting", "hello world", `"hello world"`},
+		{"embedded quote is escaped", `say "hi"`, `"say \"hi\""`},
+		{
+			"backslash run right before the closing quote is doubled",
+			`C:\Program Files\`,
+			`"C:\Program Files\\"`,
+		},
+		{
+			"backslash not followed by a quote or end is untouched",
+			`foo\bar baz`,
+			`"foo\bar baz"`,
+		},
+		{
+			"backslash run immediately before an embedded quote is doubled, plus one more to escape it",
+			`a\"b`,
+			`"a` + strings.Repeat(`\`, 3) + `"b"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := quoteCreateProcessArg(tt.arg); got != tt.want {
+				t.Errorf("quoteCreateProcessArg(%q) = %q, want %q", tt.arg, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuoteArgv(t *testing.T) {
+	tests := []struct {
+		name    string
+		argv    []string
+		quoting ArgQuoting
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "posix",
+			argv:    []string{"build", "it's fine"},
+			quoting: QuotingPosix,
+			want:    `'build' 'it'\''s fine'`,
+		},
+		{
+			name:    "powershell",
+			argv:    []string{"build", "release mode"},
+			quoting: QuotingPowerShell,
+			want:    `"build" "release mode"`,
+		},
+		{
+			name:    "windows createprocess",
+			argv:    []string{"build.exe", "path with space", `say "hi"`},
+			quoting: QuotingWindowsCreateProcess,
+			want:    `build.exe "path with space" "say \"hi\""`,
+		},
+		{
+			name:    "unknown quoting mode",
+			argv:    []string{"build"},
+			quoting: ArgQuoting("bogus"),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := quoteArgv(tt.argv, tt.quoting)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("quoteArgv(%v, %v) = %q, want %q", tt.argv, tt.quoting, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewStepCommandLineExecutableArgv(t *testing.T) {
+	t.Run("rejects an unknown quoting mode", func(t *testing.T) {
+		if _, err := NewStepCommandLineExecutableArgv("build", "make", []string{"all"}, ArgQuoting("bogus")); err == nil {
+			t.Error("expected an error for an unknown quoting mode, got nil")
+		}
+	})
+
+	t.Run("joins argv and records it for round-tripping", func(t *testing.T) {
+		step, err := NewStepCommandLineExecutableArgv("build", "make", []string{"all", "with space"}, QuotingPosix)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if want := `'all' 'with space'`; step.CommandParameters != want {
+			t.Errorf("CommandParameters = %q, want %q", step.CommandParameters, want)
+		}
+		if step.quoting != QuotingPosix {
+			t.Errorf("quoting = %v, want %v", step.quoting, QuotingPosix)
+		}
+		if len(step.argv) != 2 || step.argv[0] != "all" || step.argv[1] != "with space" {
+			t.Errorf("argv = %v, want [all with space]", step.argv)
+		}
+	})
+}
@@ -0,0 +1,134 @@
+package teamcity
+
+import "testing"
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		name  string
+		shell ShellKind
+		arg   string
+		want  string
+	}{
+		{"posix plain", ShellPosix, "hello", "'hello'"},
+		{"posix embedded quote", ShellPosix, "it's", `'it'\''s'`},
+		{"bash embedded quote", ShellBash, "it's", `'it'\''s'`},
+		{"powershell plain", ShellPowerShell, "hello world", `"hello world"`},
+		{"powershell embedded quote", ShellPowerShell, `say "hi"`, "\"say `\"hi`\"\""},
+		{"powershell embedded backtick", ShellPowerShell, "a`b", "\"a``b\""},
+		{"cmd plain", ShellCmd, "hello world", `"hello world"`},
+		{"cmd embedded quote", ShellCmd, `say "hi"`, `"say ""hi"""`},
+		{"unknown shell returns unquoted", ShellKind("fish"), "hello", "hello"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ShellQuote(tt.shell, tt.arg); got != tt.want {
+				t.Errorf("ShellQuote(%v, %q) = %q, want %q", tt.shell, tt.arg, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompilePosixScript(t *testing.T) {
+	tests := []struct {
+		name     string
+		shell    ShellKind
+		commands []string
+		want     string
+	}{
+		{
+			name:     "sh",
+			shell:    ShellPosix,
+			commands: []string{"go build ./...", "go test ./..."},
+			want: "#!/usr/bin/env sh\n" +
+				"set -e\n" +
+				"echo + 'go build ./...'\n" +
+				"go build ./...\n" +
+				"echo + 'go test ./...'\n" +
+				"go test ./...\n",
+		},
+		{
+			name:     "bash",
+			shell:    ShellBash,
+			commands: []string{"echo hi"},
+			want: "#!/usr/bin/env bash\n" +
+				"set -e\n" +
+				"echo + 'echo hi'\n" +
+				"echo hi\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := compilePosixScript(tt.shell, tt.commands); got != tt.want {
+				t.Errorf("compilePosixScript(%v, %v) = %q, want %q", tt.shell, tt.commands, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileWindowsScript(t *testing.T) {
+	tests := []struct {
+		name     string
+		shell    ShellKind
+		commands []string
+		want     string
+	}{
+		{
+			name:     "powershell",
+			shell:    ShellPowerShell,
+			commands: []string{"go build ./..."},
+			want: "$ErrorActionPreference = 'Stop'\n" +
+				`Write-Host "+ go build ./..."` + "\n" +
+				"go build ./...\n" +
+				"if ($LASTEXITCODE -ne 0) { exit $LASTEXITCODE }\n",
+		},
+		{
+			name:     "cmd",
+			shell:    ShellCmd,
+			commands: []string{"go build ./...", "go test ./..."},
+			want: "@echo off\n" +
+				"echo + go build ./...\n" +
+				"go build ./...\n" +
+				"if errorlevel 1 exit /b %errorlevel%\n" +
+				"echo + go test ./...\n" +
+				"go test ./...\n" +
+				"if errorlevel 1 exit /b %errorlevel%\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := compileWindowsScript(tt.shell, tt.commands); got != tt.want {
+				t.Errorf("compileWindowsScript(%v, %v) = %q, want %q", tt.shell, tt.commands, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewStepCommandLineCommands(t *testing.T) {
+	t.Run("requires at least one command", func(t *testing.T) {
+		if _, err := NewStepCommandLineCommands("build", nil, ShellBash); err == nil {
+			t.Error("expected an error for an empty command list, got nil")
+		}
+	})
+
+	t.Run("rejects an unknown shell", func(t *testing.T) {
+		if _, err := NewStepCommandLineCommands("build", []string{"echo hi"}, ShellKind("fish")); err == nil {
+			t.Error("expected an error for an unknown shell kind, got nil")
+		}
+	})
+
+	t.Run("compiles a posix script and records the shell", func(t *testing.T) {
+		step, err := NewStepCommandLineCommands("build", []string{"echo hi"}, ShellBash)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if step.shell != ShellBash {
+			t.Errorf("shell = %v, want %v", step.shell, ShellBash)
+		}
+		if step.CustomScript == "" {
+			t.Error("expected CustomScript to be populated")
+		}
+	})
+}